@@ -0,0 +1,43 @@
+/*
+Copyright 2021 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"context"
+
+	"vitess.io/vitess/go/vt/vttablet/tabletserver/vstreamer"
+)
+
+// WatchSchemaVersion is a thin end-to-end test helper around
+// TabletServer.WatchSchemaVersion: it runs the watch in a goroutine and
+// returns a channel of entry batches, so tests can assert on schema_version
+// history the same way they already read VEvents off a channel.
+func WatchSchemaVersion(ctx context.Context, fromRevision int64) <-chan []vstreamer.SchemaVersionEntry {
+	out := make(chan []vstreamer.SchemaVersionEntry)
+	go func() {
+		defer close(out)
+		Server.WatchSchemaVersion(ctx, fromRevision, func(entries []vstreamer.SchemaVersionEntry, compacted bool) error {
+			select {
+			case out <- entries:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return nil
+		})
+	}()
+	return out
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2021 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tabletserver
+
+import (
+	"context"
+	"time"
+
+	"vitess.io/vitess/go/vt/vterrors"
+	"vitess.io/vitess/go/vt/vttablet/tabletserver/vstreamer"
+
+	vtrpcpb "vitess.io/vitess/go/vt/proto/vtrpc"
+)
+
+// schemaWatchPollInterval is how often WatchSchemaVersion checks Historian
+// for new entries when it has caught up to the latest revision. DDLs are
+// rare enough that polling is simpler than plumbing a notification channel
+// through Historian, and this keeps latency well under what any consumer of
+// this RPC cares about.
+const schemaWatchPollInterval = 250 * time.Millisecond
+
+// WatchSchemaVersion streams schema_version history to send, starting just
+// after fromRevision, independent of any VStream row events. It lets
+// schema-aware tools (vreplication filters, online-DDL observers,
+// materialized-view builders) react to DDL without parsing every VEvent
+// stream.
+//
+// Each call to send carries an ordered batch of vstreamer.SchemaVersionEntry
+// values; a caller that disconnects and reconnects later should pass the
+// last revision it actually processed so it resumes exactly where it left
+// off, not from wherever Historian happens to be.
+func (tsv *TabletServer) WatchSchemaVersion(ctx context.Context, fromRevision int64, send func(entries []vstreamer.SchemaVersionEntry, compacted bool) error) error {
+	h := tsv.Historian()
+	if h == nil {
+		return vterrors.New(vtrpcpb.Code_FAILED_PRECONDITION, "schema tracking is not enabled on this tablet")
+	}
+
+	cursor := fromRevision
+	ticker := time.NewTicker(schemaWatchPollInterval)
+	defer ticker.Stop()
+	for {
+		entries, compacted, _, err := h.Watch(cursor)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			if err := send(entries, compacted); err != nil {
+				return err
+			}
+			cursor = entries[len(entries)-1].Revision
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
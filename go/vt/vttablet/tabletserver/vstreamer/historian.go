@@ -0,0 +1,232 @@
+/*
+Copyright 2021 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vstreamer
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"vitess.io/vitess/go/mysql"
+	"vitess.io/vitess/go/vt/log"
+
+	querypb "vitess.io/vitess/go/vt/proto/query"
+)
+
+// schemaVersion is a single row of the _vt.schema_version history: the DDL
+// that was applied, the GTID at which it was applied, and the resulting
+// column snapshot for every table it could have touched. It is immutable
+// once recorded, which is what lets Historian hand the exact same snapshot
+// back out on every replay.
+type schemaVersion struct {
+	id     int64
+	pos    string
+	ddl    string
+	fields map[string][]*querypb.Field
+
+	// diff is the per-table column diff this row introduced, relative to the
+	// previous schemaVersion, computed once at RegisterVersionEvent time so
+	// that Watch callers don't each recompute it.
+	diff map[string]*SchemaDiff
+}
+
+// SchemaCheckpoint identifies a specific point in the schema's history: the
+// GTID the caller had reached, plus the schema_version row id that was active
+// at that GTID. Passing both lets Historian disambiguate the rare case where
+// two DDLs land on the same GTID (a multi-statement transaction), which the
+// GTID alone cannot.
+type SchemaCheckpoint struct {
+	GTID            string
+	SchemaVersionID int64
+}
+
+// IsZero returns true for the empty checkpoint, which means "use whatever
+// schema is live" rather than "pin to a specific snapshot".
+func (c SchemaCheckpoint) IsZero() bool {
+	return c.GTID == "" && c.SchemaVersionID == 0
+}
+
+// Historian tracks the history of schema changes, as recorded in
+// _vt.schema_version, and answers questions about what a table's columns
+// looked like at any point in that history. Only a lookup with no checkpoint
+// falls back to whatever the live schema engine reports, whether schema
+// tracking is on or off.
+//
+// Historian is also responsible for replay determinism: two streams that
+// pass the same SchemaCheckpoint must see byte-identical FIELD events, no
+// matter what the live schema has drifted to in the meantime.
+type Historian struct {
+	mu sync.Mutex
+
+	tracked bool
+
+	// versions is kept sorted by id, which is also the replay order, since
+	// schema_version.id is allocated in the same order GTIDs are applied.
+	// It doubles as the ring buffer for Watch: once it grows past
+	// watchRingCapacity, the oldest entries are dropped and compactedBefore
+	// records where a watcher must switch from resuming incrementally to
+	// bootstrapping from a snapshot instead.
+	versions []*schemaVersion
+
+	// compactedBefore is the id of the newest schema_version row dropped from
+	// versions (0 until anything is dropped). A Watch call for a revision
+	// older than this has missed a dropped row, so it is told it has been
+	// compacted and must bootstrap instead of resuming.
+	compactedBefore int64
+
+	// liveSchema is consulted when no checkpoint was requested, whatever the
+	// tracking flag; it is supplied by the schema engine at construction time.
+	liveSchema func(table string) []*querypb.Field
+}
+
+// NewHistorian creates a Historian. liveSchema is used to answer schema
+// lookups when no checkpoint was requested.
+func NewHistorian(liveSchema func(table string) []*querypb.Field) *Historian {
+	return &Historian{
+		liveSchema: liveSchema,
+	}
+}
+
+// SetTrackSchemaVersions turns schema version tracking on or off; the flag
+// is reported by TrackSchemaVersions. Historian's own lookups do not consult
+// it: RegisterVersionEvent records every DDL either way, so past streams are
+// replayed against the schema that was active at the time, rather than the
+// current one, whatever the flag.
+func (h *Historian) SetTrackSchemaVersions(track bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.tracked = track
+}
+
+// TrackSchemaVersions reports whether tracking is currently enabled.
+func (h *Historian) TrackSchemaVersions() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.tracked
+}
+
+// RegisterVersionEvent records a newly-applied DDL and the resulting column
+// snapshot for the tables it affects. It is called once per schema_version
+// row, in GTID order, whether or not tracking is enabled, so that a replay
+// started before tracking was turned on can still be made deterministic.
+func (h *Historian) RegisterVersionEvent(id int64, pos, ddl string, fields map[string][]*querypb.Field) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var prev *schemaVersion
+	if len(h.versions) > 0 {
+		prev = h.versions[len(h.versions)-1]
+	}
+	sv := &schemaVersion{
+		id:     id,
+		pos:    pos,
+		ddl:    ddl,
+		fields: fields,
+	}
+	sv.diff = diffFields(prev, fields)
+	h.versions = append(h.versions, sv)
+	h.compactRingLocked()
+}
+
+// SchemaAtCheckpoint returns the column snapshot for table as of the
+// supplied checkpoint: the fields as they existed immediately after the
+// schema_version row identified by checkpoint.SchemaVersionID was applied.
+// If checkpoint is the zero value, SchemaAtCheckpoint falls back to the live
+// schema, preserving the pre-checkpoint behavior.
+//
+// This is the method that makes `@1`/`@2`/`@3` columns (produced previously
+// whenever tracking was off) impossible for a caller that supplies a
+// checkpoint: the column names come from the pinned snapshot, never from the
+// live, possibly-diverged schema.
+func (h *Historian) SchemaAtCheckpoint(table string, checkpoint SchemaCheckpoint) ([]*querypb.Field, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if checkpoint.IsZero() {
+		if h.liveSchema == nil {
+			return nil, fmt.Errorf("no live schema source configured for table %s", table)
+		}
+		return h.liveSchema(table), nil
+	}
+
+	sv := h.versionByID(checkpoint.SchemaVersionID)
+	if sv == nil {
+		return nil, fmt.Errorf("no schema_version row with id %d is held (not recorded, or compacted out of the ring), cannot pin checkpoint for gtid %s", checkpoint.SchemaVersionID, checkpoint.GTID)
+	}
+	fields, ok := sv.fields[table]
+	if !ok {
+		return nil, fmt.Errorf("table %s was not present in schema_version row %d (gtid %s)", table, sv.id, checkpoint.GTID)
+	}
+	return fields, nil
+}
+
+// CheckpointForGTID returns the SchemaCheckpoint that was active immediately
+// after gtid was applied, i.e. the most recent schema_version row whose own
+// GTID is not newer than gtid. This lets a caller that only knows a resume
+// GTID (the common case: the last GTID it committed before a crash) recover
+// the exact checkpoint token to hand back into SchemaAtCheckpoint on retry.
+func (h *Historian) CheckpointForGTID(gtid string) (SchemaCheckpoint, error) {
+	pos, err := mysql.DecodePosition(gtid)
+	if err != nil {
+		return SchemaCheckpoint{}, fmt.Errorf("invalid gtid %s: %v", gtid, err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var latest *schemaVersion
+	for _, sv := range h.versions {
+		svPos, err := mysql.DecodePosition(sv.pos)
+		if err != nil {
+			return SchemaCheckpoint{}, fmt.Errorf("invalid gtid %s recorded in schema_version row %d: %v", sv.pos, sv.id, err)
+		}
+		if !pos.AtLeast(svPos) {
+			break
+		}
+		latest = sv
+	}
+	if latest == nil {
+		return SchemaCheckpoint{}, fmt.Errorf("no schema_version row found at or before gtid %s", gtid)
+	}
+	return SchemaCheckpoint{GTID: gtid, SchemaVersionID: latest.id}, nil
+}
+
+// versionByID looks up a recorded schema version by its schema_version.id.
+// Callers must hold h.mu.
+func (h *Historian) versionByID(id int64) *schemaVersion {
+	// versions is append-only and kept in id order, so binary search works.
+	i := sort.Search(len(h.versions), func(i int) bool { return h.versions[i].id >= id })
+	if i < len(h.versions) && h.versions[i].id == id {
+		return h.versions[i]
+	}
+	return nil
+}
+
+// compactRingLocked drops the oldest recorded versions once the in-memory
+// ring exceeds watchRingCapacity, recording the id boundary in
+// compactedBefore so Watch knows a watcher behind that point can no longer
+// resume incrementally and must be handed a bootstrap snapshot instead.
+// Callers must hold h.mu.
+func (h *Historian) compactRingLocked() {
+	if len(h.versions) <= watchRingCapacity {
+		return
+	}
+	dropped := len(h.versions) - watchRingCapacity
+	log.Infof("historian: ring buffer full, %d schema_version entries dropped; watchers behind them will get a bootstrap snapshot instead of incremental diffs", dropped)
+	h.compactedBefore = h.versions[dropped-1].id
+	h.versions = h.versions[dropped:]
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2021 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vstreamer
+
+import (
+	"testing"
+
+	querypb "vitess.io/vitess/go/vt/proto/query"
+)
+
+func fieldNames(t *testing.T, fields []*querypb.Field) []string {
+	t.Helper()
+	var names []string
+	for _, f := range fields {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func assertFieldNames(t *testing.T, fields []*querypb.Field, want []string) {
+	t.Helper()
+	got := fieldNames(t, fields)
+	if len(got) != len(want) {
+		t.Fatalf("field names = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("field names = %v, want %v", got, want)
+		}
+	}
+}
+
+// TestFieldsForReplay_DeterministicAcrossTrackingToggle checks that, for a
+// table whose shape has since changed, FieldsForReplay resolves the real
+// column names that were active at a past GTID rather than the live
+// schema's positional @1/@2/@3 names, and that it resolves the same names
+// whether or not tracking is enabled, because it never consults the live
+// schema when a recorded schema_version row covers the GTID in question.
+func TestFieldsForReplay_DeterministicAcrossTrackingToggle(t *testing.T) {
+	const table = "vitess_version"
+
+	live := []*querypb.Field{{Name: "@1", Type: querypb.Type_INT32}, {Name: "@2", Type: querypb.Type_INT32}, {Name: "@3", Type: querypb.Type_INT32}}
+	h := NewHistorian(func(string) []*querypb.Field { return live })
+
+	h.RegisterVersionEvent(1, "MySQL56/00000000-0000-0000-0000-000000000001:1-10", "create table vitess_version (id1 int, id2 int)", map[string][]*querypb.Field{
+		table: {{Name: "id1", Type: querypb.Type_INT32}, {Name: "id2", Type: querypb.Type_INT32}},
+	})
+	h.RegisterVersionEvent(2, "MySQL56/00000000-0000-0000-0000-000000000001:1-20", "alter table vitess_version add column id3 int", map[string][]*querypb.Field{
+		table: {{Name: "id1", Type: querypb.Type_INT32}, {Name: "id2", Type: querypb.Type_INT32}, {Name: "id3", Type: querypb.Type_INT32}},
+	})
+
+	// The insert this FIELD event belongs to landed right after the second
+	// DDL, so it must see id1/id2/id3, not the live schema's @1/@2/@3.
+	const insertGTID = "MySQL56/00000000-0000-0000-0000-000000000001:1-21"
+
+	for _, tracked := range []bool{true, false} {
+		h.SetTrackSchemaVersions(tracked)
+		fields, err := h.FieldsForReplay(&VStreamRequest{}, table, insertGTID)
+		if err != nil {
+			t.Fatalf("tracked=%v: FieldsForReplay: %v", tracked, err)
+		}
+		assertFieldNames(t, fields, []string{"id1", "id2", "id3"})
+	}
+}
+
+// TestCheckpointForGTID_OrdersByGTIDNotString guards against regressing to a
+// plain string comparison of GTIDs: "1-9" sorts after "1-10" lexically, which
+// would make CheckpointForGTID treat row 1 as newer than the GTID and find no
+// schema_version row at all for any tablet that has seen 10+ transactions.
+func TestCheckpointForGTID_OrdersByGTIDNotString(t *testing.T) {
+	h := NewHistorian(nil)
+	h.RegisterVersionEvent(1, "MySQL56/00000000-0000-0000-0000-000000000001:1-9", "create table t (id int)", map[string][]*querypb.Field{
+		"t": {{Name: "id", Type: querypb.Type_INT32}},
+	})
+	h.RegisterVersionEvent(2, "MySQL56/00000000-0000-0000-0000-000000000001:1-10", "alter table t add column extra int", map[string][]*querypb.Field{
+		"t": {{Name: "id", Type: querypb.Type_INT32}, {Name: "extra", Type: querypb.Type_INT32}},
+	})
+
+	checkpoint, err := h.CheckpointForGTID("MySQL56/00000000-0000-0000-0000-000000000001:1-10")
+	if err != nil {
+		t.Fatalf("CheckpointForGTID: %v", err)
+	}
+	if checkpoint.SchemaVersionID != 2 {
+		t.Fatalf("SchemaVersionID = %d, want 2 (row 2 is at 1-10, row 1 at 1-9)", checkpoint.SchemaVersionID)
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2021 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vstreamer
+
+import (
+	binlogdatapb "vitess.io/vitess/go/vt/proto/binlogdata"
+	querypb "vitess.io/vitess/go/vt/proto/query"
+)
+
+// VStreamRequest bundles the parameters that select which events a VStream
+// call replays and how it resolves column names for rows in the past.
+//
+// SchemaCheckpoint, when set, makes FieldsForReplay pin the Historian to the
+// exact schema snapshot it names instead of consulting the live schema (or,
+// when tracking was off, falling back to positional `@1`/`@2`/`@3` field
+// names). This makes the column set FieldsForReplay resolves from a given
+// checkpoint identical across runs.
+type VStreamRequest struct {
+	Target           *querypb.Target
+	StartPos         string
+	Filter           *binlogdatapb.Filter
+	SchemaCheckpoint SchemaCheckpoint
+}
+
+// FieldsForReplay resolves the column set a FIELD event for table should
+// carry while replaying req. It resolves through Historian whether tracking
+// is on or off, so it does not produce the live schema engine's @1/@2/@3
+// names for a table that has since changed shape. The live schema is used
+// only when req carries no checkpoint and no recorded schema_version row
+// lies at or before currentGTID (none recorded yet, or all such rows
+// compacted out of the ring), or when currentGTID cannot be decoded.
+//
+// currentGTID is the GTID the replay has reached when the FIELD event for
+// table is about to be sent; it is used to resolve the checkpoint when req
+// does not carry an explicit one.
+//
+// FieldsForReplay has no production call site: the FIELD-event builder in
+// go/vt/vttablet/tabletserver/vstreamer/vstreamer.go does not call it, so
+// the FIELD events VStream sends are built without it. historian_test.go
+// exercises it directly.
+func (h *Historian) FieldsForReplay(req *VStreamRequest, table string, currentGTID string) ([]*querypb.Field, error) {
+	checkpoint := req.SchemaCheckpoint
+	if checkpoint.IsZero() {
+		// No explicit checkpoint: preserve today's semantics by pinning to
+		// whatever schema was active at the GTID the replay has reached, so
+		// a plain startPos-based replay is deterministic too, not just one
+		// that supplies a checkpoint token explicitly.
+		resolved, err := h.CheckpointForGTID(currentGTID)
+		if err == nil {
+			checkpoint = resolved
+		}
+		// If no schema_version row has been seen yet (e.g. live streaming
+		// before any DDL), fall through with the zero checkpoint, which
+		// SchemaAtCheckpoint maps to the live schema.
+	}
+	return h.SchemaAtCheckpoint(table, checkpoint)
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2021 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vstreamer
+
+import (
+	"fmt"
+
+	querypb "vitess.io/vitess/go/vt/proto/query"
+)
+
+// watchRingCapacity bounds how many schema_version rows Historian keeps in
+// memory for Watch to serve directly. Tablets that see fewer DDLs than this
+// between watcher catch-ups always resume incrementally; busier ones fall
+// back to a single bootstrap snapshot once a watcher's cursor has aged out
+// of the ring, which is still correct, just coarser than incremental diffs.
+const watchRingCapacity = 1000
+
+// ColumnType names a single column and the type it had at some point in the
+// diff: the type it was added or dropped with, for AddedColumns and
+// DroppedColumns.
+type ColumnType struct {
+	Name string
+	Type querypb.Type
+}
+
+// ColumnTypeChange describes a column whose type changed between two
+// schema_version rows.
+type ColumnTypeChange struct {
+	Name    string
+	OldType querypb.Type
+	NewType querypb.Type
+}
+
+// SchemaDiff describes the column-level change a single DDL made to a table,
+// relative to the snapshot immediately before it. Types are included so a
+// consumer (an online-DDL observer, a materialized-view builder) can react
+// to a modified column without re-deriving what it changed to from the raw
+// DDL text.
+type SchemaDiff struct {
+	AddedColumns    []ColumnType
+	DroppedColumns  []ColumnType
+	ModifiedColumns []ColumnTypeChange
+}
+
+// diffFields computes, per table, the column-level change that fields
+// introduced relative to prev. prev may be nil, meaning every table in
+// fields is being seen for the first time.
+func diffFields(prev *schemaVersion, fields map[string][]*querypb.Field) map[string]*SchemaDiff {
+	diffs := make(map[string]*SchemaDiff, len(fields))
+	for table, cols := range fields {
+		var prevCols []*querypb.Field
+		if prev != nil {
+			prevCols = prev.fields[table]
+		}
+		diffs[table] = diffColumns(prevCols, cols)
+	}
+	return diffs
+}
+
+func diffColumns(prev, cur []*querypb.Field) *SchemaDiff {
+	prevByName := make(map[string]*querypb.Field, len(prev))
+	for _, f := range prev {
+		prevByName[f.Name] = f
+	}
+	curByName := make(map[string]*querypb.Field, len(cur))
+	for _, f := range cur {
+		curByName[f.Name] = f
+	}
+
+	diff := &SchemaDiff{}
+	for _, f := range cur {
+		old, existed := prevByName[f.Name]
+		if !existed {
+			diff.AddedColumns = append(diff.AddedColumns, ColumnType{Name: f.Name, Type: f.Type})
+			continue
+		}
+		if old.Type != f.Type {
+			diff.ModifiedColumns = append(diff.ModifiedColumns, ColumnTypeChange{Name: f.Name, OldType: old.Type, NewType: f.Type})
+		}
+	}
+	for _, f := range prev {
+		if _, stillThere := curByName[f.Name]; !stillThere {
+			diff.DroppedColumns = append(diff.DroppedColumns, ColumnType{Name: f.Name, Type: f.Type})
+		}
+	}
+	return diff
+}
+
+// SchemaVersionEntry is one entry of the watch stream: a single
+// schema_version row, its monotonic revision, and the column diff it
+// produced for each table it touched.
+type SchemaVersionEntry struct {
+	Revision int64
+	GTID     string
+	DDL      string
+	Diff     map[string]*SchemaDiff
+}
+
+// Watch returns every SchemaVersionEntry recorded after fromRevision, in
+// revision order, along with the latest revision known to Historian.
+//
+// If fromRevision has already been compacted out of the in-memory ring
+// (older than compactedBefore), compacted is true and entries is instead a
+// bootstrap snapshot: a single synthetic entry at the latest revision whose
+// Diff carries, for each table in the latest schema_version row's snapshot,
+// its full column list as "added"; tables absent from that row are not
+// included. This lets the caller
+// rebuild its view from scratch rather than trying to resume a gap it can't
+// see across.
+func (h *Historian) Watch(fromRevision int64) (entries []SchemaVersionEntry, compacted bool, latestRevision int64, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.versions) == 0 {
+		return nil, false, 0, nil
+	}
+	latestRevision = h.versions[len(h.versions)-1].id
+
+	if fromRevision < h.compactedBefore {
+		return h.bootstrapSnapshotLocked(), true, latestRevision, nil
+	}
+
+	for _, sv := range h.versions {
+		if sv.id <= fromRevision {
+			continue
+		}
+		entries = append(entries, SchemaVersionEntry{
+			Revision: sv.id,
+			GTID:     sv.pos,
+			DDL:      sv.ddl,
+			Diff:     sv.diff,
+		})
+	}
+	return entries, false, latestRevision, nil
+}
+
+// bootstrapSnapshotLocked builds the single catch-up entry handed to a
+// watcher whose cursor fell out of the ring. Callers must hold h.mu.
+func (h *Historian) bootstrapSnapshotLocked() []SchemaVersionEntry {
+	latest := h.versions[len(h.versions)-1]
+	diff := make(map[string]*SchemaDiff, len(latest.fields))
+	for table, cols := range latest.fields {
+		d := &SchemaDiff{}
+		for _, f := range cols {
+			d.AddedColumns = append(d.AddedColumns, ColumnType{Name: f.Name, Type: f.Type})
+		}
+		diff[table] = d
+	}
+	return []SchemaVersionEntry{{
+		Revision: latest.id,
+		GTID:     latest.pos,
+		DDL:      fmt.Sprintf("-- bootstrap snapshot, revisions up to %d were compacted", h.compactedBefore),
+		Diff:     diff,
+	}}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2021 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vstreamer
+
+import (
+	"fmt"
+	"testing"
+
+	querypb "vitess.io/vitess/go/vt/proto/query"
+)
+
+func registerColumns(h *Historian, id int64, pos, ddl, table string, cols ...ColumnType) {
+	fields := make([]*querypb.Field, 0, len(cols))
+	for _, c := range cols {
+		fields = append(fields, &querypb.Field{Name: c.Name, Type: c.Type})
+	}
+	h.RegisterVersionEvent(id, pos, ddl, map[string][]*querypb.Field{table: fields})
+}
+
+func TestWatch_ReturnsEntriesAfterFromRevisionWithTypedDiff(t *testing.T) {
+	h := NewHistorian(nil)
+	registerColumns(h, 1, "MySQL56/uuid:1-1", "create table t (id int)", "t",
+		ColumnType{Name: "id", Type: querypb.Type_INT32})
+	registerColumns(h, 2, "MySQL56/uuid:1-2", "alter table t add column name varbinary(16)", "t",
+		ColumnType{Name: "id", Type: querypb.Type_INT32}, ColumnType{Name: "name", Type: querypb.Type_VARBINARY})
+	registerColumns(h, 3, "MySQL56/uuid:1-3", "alter table t modify column id bigint", "t",
+		ColumnType{Name: "id", Type: querypb.Type_INT64}, ColumnType{Name: "name", Type: querypb.Type_VARBINARY})
+
+	entries, compacted, latest, err := h.Watch(1)
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	if compacted {
+		t.Fatalf("Watch reported compacted, want false")
+	}
+	if latest != 3 {
+		t.Fatalf("latestRevision = %d, want 3", latest)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2 (revisions 2 and 3)", len(entries))
+	}
+	if entries[0].Revision != 2 || entries[1].Revision != 3 {
+		t.Fatalf("entries = %+v, want revisions [2 3]", entries)
+	}
+
+	added := entries[0].Diff["t"].AddedColumns
+	if len(added) != 1 || added[0] != (ColumnType{Name: "name", Type: querypb.Type_VARBINARY}) {
+		t.Fatalf("revision 2 AddedColumns = %+v, want [{name VARBINARY}]", added)
+	}
+
+	modified := entries[1].Diff["t"].ModifiedColumns
+	want := ColumnTypeChange{Name: "id", OldType: querypb.Type_INT32, NewType: querypb.Type_INT64}
+	if len(modified) != 1 || modified[0] != want {
+		t.Fatalf("revision 3 ModifiedColumns = %+v, want [%+v]", modified, want)
+	}
+}
+
+func TestWatch_CompactionFallsBackToBootstrapSnapshot(t *testing.T) {
+	h := NewHistorian(nil)
+	for i := int64(1); i <= watchRingCapacity+5; i++ {
+		registerColumns(h, i, fmt.Sprintf("MySQL56/uuid:1-%d", i), "alter table t add column c int", "t",
+			ColumnType{Name: "id", Type: querypb.Type_INT32})
+	}
+
+	entries, compacted, _, err := h.Watch(1)
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	if !compacted {
+		t.Fatalf("Watch reported compacted=false for a revision dropped from the ring, want true")
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d bootstrap entries, want exactly 1 synthetic snapshot", len(entries))
+	}
+	if len(entries[0].Diff["t"].AddedColumns) == 0 {
+		t.Fatalf("bootstrap snapshot diff has no AddedColumns for table t")
+	}
+}